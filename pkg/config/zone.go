@@ -0,0 +1,955 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultZoneName is the name of the built-in zone that every range falls
+// back to when no more specific zone config applies.
+const DefaultZoneName = "default"
+
+// NamedZones maps built-in, special-purpose zone names (e.g. "default",
+// written ".default" in a CLI zone specifier) to
+// the range/table IDs they configure. It is consulted by ResolveZoneSpecifier
+// whenever a CLI zone specifier begins with a leading dot.
+var NamedZones = map[string]uint32{
+	DefaultZoneName: 0,
+}
+
+// NamedZonesByID is the inverse of NamedZones.
+var NamedZonesByID = map[uint32]string{
+	0: DefaultZoneName,
+}
+
+// minRangeMaxBytes is the smallest RangeMaxBytes that Validate will accept.
+const minRangeMaxBytes = 64 << 20 // 64MB
+
+// Constraint_Type indicates whether a constraint is merely preferred
+// (POSITIVE), mandatory (REQUIRED), or forbidden (PROHIBITED) for replica
+// placement.
+type Constraint_Type int32
+
+// Constraint type values. The zero value, POSITIVE, lets the allocator use a
+// matching store as a tie-breaker without requiring it.
+const (
+	Constraint_POSITIVE   Constraint_Type = 0
+	Constraint_REQUIRED   Constraint_Type = 1
+	Constraint_PROHIBITED Constraint_Type = 2
+)
+
+// Constraint is a single attribute or locality tag that a replica's store
+// must (REQUIRED), must not (PROHIBITED), or may preferably (POSITIVE) match.
+// Key is empty for plain attribute constraints (e.g. "ssd"); it is set for
+// locality constraints (e.g. "region=us-east1").
+type Constraint struct {
+	Type  Constraint_Type
+	Key   string
+	Value string
+}
+
+// String renders the constraint using the compact +/-/bare syntax accepted
+// by ParseConstraint, e.g. "foo", "+duck=foo", "-duck=foo".
+func (c Constraint) String() string {
+	var prefix string
+	switch c.Type {
+	case Constraint_REQUIRED:
+		prefix = "+"
+	case Constraint_PROHIBITED:
+		prefix = "-"
+	}
+	if c.Key != "" {
+		return fmt.Sprintf("%s%s=%s", prefix, c.Key, c.Value)
+	}
+	return fmt.Sprintf("%s%s", prefix, c.Value)
+}
+
+// MarshalJSON implements json.Marshaler. It encodes the constraint using the
+// same compact syntax as Constraint.String, rather than the verbose
+// {"type":...,"key":...,"value":...} form.
+func (c Constraint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Constraint) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseConstraint(s)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// ParseConstraint parses the compact constraint syntax produced by
+// Constraint.String.
+func ParseConstraint(s string) (Constraint, error) {
+	var c Constraint
+	switch {
+	case strings.HasPrefix(s, "+"):
+		c.Type = Constraint_REQUIRED
+		s = s[1:]
+	case strings.HasPrefix(s, "-"):
+		c.Type = Constraint_PROHIBITED
+		s = s[1:]
+	default:
+		c.Type = Constraint_POSITIVE
+	}
+	if i := strings.IndexByte(s, '='); i != -1 {
+		c.Key, c.Value = s[:i], s[i+1:]
+	} else {
+		c.Value = s
+	}
+	if c.Value == "" {
+		return Constraint{}, fmt.Errorf("invalid constraint: %q", s)
+	}
+	return c, nil
+}
+
+// ConstraintsConjunction is a set of constraints that together apply to some
+// number of a range's replicas. A NumReplicas of 0 means the conjunction
+// applies to whichever replicas are left unconstrained by every other
+// conjunction, i.e. it is the "applies to all replicas" case.
+type ConstraintsConjunction struct {
+	NumReplicas uint32       `json:"num_replicas,omitempty"`
+	Constraints []Constraint `json:"constraints"`
+}
+
+// GCPolicy dictates how long values for a given range should be kept before
+// garbage collection.
+type GCPolicy struct {
+	// TTLSeconds specifies the maximum age of a value before it's
+	// garbage collected. Only older versions of values are garbage
+	// collected. Specifying a zero duration disables garbage
+	// collection.
+	TTLSeconds int32 `json:"ttlseconds"`
+}
+
+// ZoneConfig holds configuration that applies to one or more ranges.
+//
+// Constraints bind a subset of a range's replicas (the sum of each
+// conjunction's NumReplicas must not exceed NumReplicas; any remaining
+// replicas are unconstrained). LeasePreferences is consulted, in order, by
+// the allocator when deciding where to place the range lease; unlike
+// Constraints, a LeasePreferences entry's NumReplicas is unused. Subzones
+// overrides this config for specific indexes or partitions of the table it
+// applies to.
+type ZoneConfig struct {
+	RangeMinBytes    int64
+	RangeMaxBytes    int64
+	GC               GCPolicy
+	NumReplicas      int32
+	Constraints      []ConstraintsConjunction
+	LeasePreferences []ConstraintsConjunction
+	Subzones         []Subzone
+}
+
+// Subzone is a ZoneConfig override that applies to a single index, or a
+// single partition of an index, of the table that the enclosing ZoneConfig
+// applies to. PartitionName is empty when the Subzone overrides an index as
+// a whole.
+type Subzone struct {
+	IndexID       uint32
+	PartitionName string
+	Config        ZoneConfig
+}
+
+// Reset implements proto.Message.
+func (z *ZoneConfig) Reset() { *z = ZoneConfig{} }
+
+// String implements proto.Message.
+func (z *ZoneConfig) String() string { return fmt.Sprintf("%+v", *z) }
+
+// ProtoMessage implements proto.Message.
+func (*ZoneConfig) ProtoMessage() {}
+
+// DefaultZoneConfig returns the default zone config applied when no more
+// specific zone config has been set.
+func DefaultZoneConfig() ZoneConfig {
+	return ZoneConfig{
+		NumReplicas:   3,
+		RangeMinBytes: 1 << 20,
+		RangeMaxBytes: minRangeMaxBytes,
+		GC: GCPolicy{
+			TTLSeconds: 25 * 60 * 60,
+		},
+	}
+}
+
+// Validate verifies that the zone config is well-formed.
+func (z ZoneConfig) Validate() error {
+	switch {
+	case z.NumReplicas == 0:
+		return fmt.Errorf("attributes for at least one replica must be specified in zone config")
+	case z.NumReplicas < 0:
+		return fmt.Errorf("at least 1 replica is required")
+	case z.NumReplicas == 2:
+		return fmt.Errorf("at least 3 replicas are required for multi-replica configurations")
+	}
+	if z.RangeMaxBytes < minRangeMaxBytes {
+		return fmt.Errorf("RangeMaxBytes %d less than minimum allowed (%d)", z.RangeMaxBytes, minRangeMaxBytes)
+	}
+	if z.RangeMinBytes >= z.RangeMaxBytes {
+		return fmt.Errorf(
+			"RangeMinBytes %d is greater than or equal to RangeMaxBytes %d", z.RangeMinBytes, z.RangeMaxBytes)
+	}
+	var total uint32
+	for _, c := range z.Constraints {
+		total += c.NumReplicas
+	}
+	if total > uint32(z.NumReplicas) {
+		return fmt.Errorf(
+			"the number of replicas specified in constraints (%d) is greater than the configured number of replicas (%d)",
+			total, z.NumReplicas)
+	}
+	return nil
+}
+
+func constraintsToStrings(cs []Constraint) []string {
+	strs := make([]string, len(cs))
+	for i, c := range cs {
+		strs[i] = c.String()
+	}
+	return strs
+}
+
+func constraintsFromYAMLList(list []interface{}) ([]Constraint, error) {
+	cs := make([]Constraint, len(list))
+	for i, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("constraint %v is not a string", v)
+		}
+		c, err := ParseConstraint(s)
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+	}
+	return cs, nil
+}
+
+func constraintsFromStrings(list []string) ([]Constraint, error) {
+	ifaces := make([]interface{}, len(list))
+	for i, s := range list {
+		ifaces[i] = s
+	}
+	return constraintsFromYAMLList(ifaces)
+}
+
+// rawConstraints decodes the "constraints" YAML key, which may be either a
+// flat list (the shorthand meaning "applies to all replicas") or a mapping
+// from replica count to constraint list. Because the same count can appear
+// more than once (e.g. "2 in us-east1, 2 in us-west1"), the mapping form is
+// decoded as a yaml.MapSlice rather than a Go map, which would silently drop
+// the duplicate key.
+type rawConstraints struct {
+	flat   []string
+	mapped yaml.MapSlice
+	isMap  bool
+}
+
+func (r *rawConstraints) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var flat []string
+	if err := unmarshal(&flat); err == nil {
+		r.flat = flat
+		return nil
+	}
+	var ms yaml.MapSlice
+	if err := unmarshal(&ms); err != nil {
+		return err
+	}
+	r.mapped = ms
+	r.isMap = true
+	return nil
+}
+
+func (r rawConstraints) toConjunctions() ([]ConstraintsConjunction, error) {
+	if r.isMap {
+		result := make([]ConstraintsConjunction, len(r.mapped))
+		for i, item := range r.mapped {
+			n, ok := item.Key.(int)
+			if !ok {
+				return nil, fmt.Errorf("constraints key %v must be an integer replica count", item.Key)
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("constraints replica count %d must be non-negative", n)
+			}
+			list, ok := item.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("constraints value for %v must be a list", item.Key)
+			}
+			cs, err := constraintsFromYAMLList(list)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = ConstraintsConjunction{NumReplicas: uint32(n), Constraints: cs}
+		}
+		return result, nil
+	}
+	if len(r.flat) == 0 {
+		return nil, nil
+	}
+	cs, err := constraintsFromStrings(r.flat)
+	if err != nil {
+		return nil, err
+	}
+	return []ConstraintsConjunction{{Constraints: cs}}, nil
+}
+
+// subzoneYAML is the YAML representation of a Subzone: the index and,
+// optionally, partition it overrides, plus the nested config itself.
+type subzoneYAML struct {
+	IndexID       uint32     `yaml:"index_id"`
+	PartitionName string     `yaml:"partition,omitempty"`
+	Config        ZoneConfig `yaml:"config"`
+}
+
+// MarshalYAML implements yaml.Marshaler. It renders a lone conjunction with
+// NumReplicas 0 as the flat "applies to all replicas" shorthand and anything
+// more complex as a map from replica count to constraint list. Constraints
+// and lease preferences are rendered in flow style (e.g. "[+region=us-east1]")
+// to match the compact, single-line form this config has always used.
+func (z ZoneConfig) MarshalYAML() (interface{}, error) {
+	aux := struct {
+		RangeMinBytes    int64         `yaml:"range_min_bytes"`
+		RangeMaxBytes    int64         `yaml:"range_max_bytes"`
+		GC               GCPolicy      `yaml:"gc"`
+		NumReplicas      int32         `yaml:"num_replicas"`
+		Constraints      interface{}   `yaml:"constraints,omitempty,flow"`
+		LeasePreferences [][]string    `yaml:"lease_preferences,omitempty,flow"`
+		Subzones         []subzoneYAML `yaml:"subzones,omitempty"`
+	}{
+		RangeMinBytes: z.RangeMinBytes,
+		RangeMaxBytes: z.RangeMaxBytes,
+		GC:            z.GC,
+		NumReplicas:   z.NumReplicas,
+	}
+
+	switch {
+	case len(z.Constraints) == 0:
+	case len(z.Constraints) == 1 && z.Constraints[0].NumReplicas == 0:
+		aux.Constraints = constraintsToStrings(z.Constraints[0].Constraints)
+	default:
+		ms := make(yaml.MapSlice, len(z.Constraints))
+		for i, cc := range z.Constraints {
+			ms[i] = yaml.MapItem{Key: int(cc.NumReplicas), Value: constraintsToStrings(cc.Constraints)}
+		}
+		aux.Constraints = ms
+	}
+
+	for _, lp := range z.LeasePreferences {
+		aux.LeasePreferences = append(aux.LeasePreferences, constraintsToStrings(lp.Constraints))
+	}
+
+	for _, sz := range z.Subzones {
+		aux.Subzones = append(aux.Subzones, subzoneYAML{
+			IndexID:       sz.IndexID,
+			PartitionName: sz.PartitionName,
+			Config:        sz.Config,
+		})
+	}
+
+	return aux, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (z *ZoneConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var aux struct {
+		RangeMinBytes    int64          `yaml:"range_min_bytes"`
+		RangeMaxBytes    int64          `yaml:"range_max_bytes"`
+		GC               GCPolicy       `yaml:"gc"`
+		NumReplicas      int32          `yaml:"num_replicas"`
+		Constraints      rawConstraints `yaml:"constraints"`
+		LeasePreferences [][]string     `yaml:"lease_preferences"`
+		Subzones         []subzoneYAML  `yaml:"subzones"`
+	}
+	if err := unmarshal(&aux); err != nil {
+		return err
+	}
+
+	constraints, err := aux.Constraints.toConjunctions()
+	if err != nil {
+		return err
+	}
+
+	var leasePreferences []ConstraintsConjunction
+	for _, lp := range aux.LeasePreferences {
+		cs, err := constraintsFromStrings(lp)
+		if err != nil {
+			return err
+		}
+		leasePreferences = append(leasePreferences, ConstraintsConjunction{Constraints: cs})
+	}
+
+	var subzones []Subzone
+	for _, sz := range aux.Subzones {
+		subzones = append(subzones, Subzone{
+			IndexID:       sz.IndexID,
+			PartitionName: sz.PartitionName,
+			Config:        sz.Config,
+		})
+	}
+
+	*z = ZoneConfig{
+		RangeMinBytes:    aux.RangeMinBytes,
+		RangeMaxBytes:    aux.RangeMaxBytes,
+		GC:               aux.GC,
+		NumReplicas:      aux.NumReplicas,
+		Constraints:      constraints,
+		LeasePreferences: leasePreferences,
+		Subzones:         subzones,
+	}
+	return nil
+}
+
+// subzoneJSON is the JSON representation of a Subzone.
+type subzoneJSON struct {
+	IndexID       uint32     `json:"index_id"`
+	PartitionName string     `json:"partition,omitempty"`
+	Config        ZoneConfig `json:"config"`
+}
+
+// zoneConfigJSON is the JSON representation of a ZoneConfig. Constraints is
+// left untyped because it takes one of two shapes: a flat array of compact
+// constraint strings when there is a single conjunction with NumReplicas 0
+// (the common "applies to all replicas" case), or an array of
+// {num_replicas, constraints} objects otherwise.
+type zoneConfigJSON struct {
+	RangeMinBytes    int64          `json:"range_min_bytes"`
+	RangeMaxBytes    int64          `json:"range_max_bytes"`
+	GC               GCPolicy       `json:"gc"`
+	NumReplicas      int32          `json:"num_replicas"`
+	Constraints      interface{}    `json:"constraints,omitempty"`
+	LeasePreferences [][]Constraint `json:"lease_preferences,omitempty"`
+	Subzones         []subzoneJSON  `json:"subzones,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, using the same compact constraint
+// syntax as MarshalYAML and choosing the flat constraints form in the same
+// case; otherwise constraints are an array of objects rather than a map.
+func (z ZoneConfig) MarshalJSON() ([]byte, error) {
+	aux := zoneConfigJSON{
+		RangeMinBytes: z.RangeMinBytes,
+		RangeMaxBytes: z.RangeMaxBytes,
+		GC:            z.GC,
+		NumReplicas:   z.NumReplicas,
+	}
+
+	switch {
+	case len(z.Constraints) == 0:
+	case len(z.Constraints) == 1 && z.Constraints[0].NumReplicas == 0:
+		aux.Constraints = z.Constraints[0].Constraints
+	default:
+		aux.Constraints = z.Constraints
+	}
+
+	for _, lp := range z.LeasePreferences {
+		aux.LeasePreferences = append(aux.LeasePreferences, lp.Constraints)
+	}
+
+	for _, sz := range z.Subzones {
+		aux.Subzones = append(aux.Subzones, subzoneJSON{
+			IndexID:       sz.IndexID,
+			PartitionName: sz.PartitionName,
+			Config:        sz.Config,
+		})
+	}
+
+	return json.Marshal(aux)
+}
+
+// constraintsConjunctionsFromJSON decodes the "constraints" JSON key, trying
+// the flat array form before falling back to the array-of-conjunctions form.
+func constraintsConjunctionsFromJSON(raw json.RawMessage) ([]ConstraintsConjunction, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var flat []Constraint
+	if err := json.Unmarshal(raw, &flat); err == nil {
+		if len(flat) == 0 {
+			return nil, nil
+		}
+		return []ConstraintsConjunction{{Constraints: flat}}, nil
+	}
+	var conjunctions []ConstraintsConjunction
+	if err := json.Unmarshal(raw, &conjunctions); err != nil {
+		return nil, err
+	}
+	return conjunctions, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (z *ZoneConfig) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		RangeMinBytes    int64           `json:"range_min_bytes"`
+		RangeMaxBytes    int64           `json:"range_max_bytes"`
+		GC               GCPolicy        `json:"gc"`
+		NumReplicas      int32           `json:"num_replicas"`
+		Constraints      json.RawMessage `json:"constraints"`
+		LeasePreferences [][]Constraint  `json:"lease_preferences"`
+		Subzones         []subzoneJSON   `json:"subzones"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	constraints, err := constraintsConjunctionsFromJSON(aux.Constraints)
+	if err != nil {
+		return err
+	}
+
+	var leasePreferences []ConstraintsConjunction
+	for _, lp := range aux.LeasePreferences {
+		leasePreferences = append(leasePreferences, ConstraintsConjunction{Constraints: lp})
+	}
+
+	var subzones []Subzone
+	for _, sz := range aux.Subzones {
+		subzones = append(subzones, Subzone{
+			IndexID:       sz.IndexID,
+			PartitionName: sz.PartitionName,
+			Config:        sz.Config,
+		})
+	}
+
+	*z = ZoneConfig{
+		RangeMinBytes:    aux.RangeMinBytes,
+		RangeMaxBytes:    aux.RangeMaxBytes,
+		GC:               aux.GC,
+		NumReplicas:      aux.NumReplicas,
+		Constraints:      constraints,
+		LeasePreferences: leasePreferences,
+		Subzones:         subzones,
+	}
+	return nil
+}
+
+// ZoneConfigDiff describes how one ZoneConfig differs from another: the
+// scalar fields that changed, plus any constraints conjunctions that were
+// added or removed wholesale. It lets callers surface "what actually
+// changed" when, for example, `ALTER ... CONFIGURE ZONE` overlays a partial
+// config on an inherited one.
+type ZoneConfigDiff struct {
+	ChangedFields      []string
+	AddedConstraints   []ConstraintsConjunction
+	RemovedConstraints []ConstraintsConjunction
+}
+
+// Empty returns true if the diff reflects no differences.
+func (d ZoneConfigDiff) Empty() bool {
+	return len(d.ChangedFields) == 0 && len(d.AddedConstraints) == 0 && len(d.RemovedConstraints) == 0
+}
+
+// constraintsConjunctionKey returns a string that uniquely identifies a
+// conjunction's replica count and (order-independent) constraint set, for
+// use as a map key when diffing.
+func constraintsConjunctionKey(cc ConstraintsConjunction) string {
+	parts := make([]string, len(cc.Constraints))
+	for i, c := range cc.Constraints {
+		parts[i] = c.String()
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%d:%s", cc.NumReplicas, strings.Join(parts, ","))
+}
+
+// diffConstraintsConjunctions returns the conjunctions present in a but not
+// b (added) and those present in b but not a (removed), treating each side
+// as a multiset so that duplicate conjunctions (e.g. two "2 replicas in
+// region=us-east1" entries) are matched up rather than both reported as
+// changed.
+func diffConstraintsConjunctions(a, b []ConstraintsConjunction) (added, removed []ConstraintsConjunction) {
+	remaining := make(map[string]int, len(b))
+	for _, cc := range b {
+		remaining[constraintsConjunctionKey(cc)]++
+	}
+	for _, cc := range a {
+		k := constraintsConjunctionKey(cc)
+		if remaining[k] > 0 {
+			remaining[k]--
+			continue
+		}
+		added = append(added, cc)
+	}
+
+	remaining = make(map[string]int, len(a))
+	for _, cc := range a {
+		remaining[constraintsConjunctionKey(cc)]++
+	}
+	for _, cc := range b {
+		k := constraintsConjunctionKey(cc)
+		if remaining[k] > 0 {
+			remaining[k]--
+			continue
+		}
+		removed = append(removed, cc)
+	}
+	return added, removed
+}
+
+// DiffFrom computes how z differs from other: which scalar fields changed,
+// and which constraints conjunctions were added or removed. It does not
+// descend into Subzones or LeasePreferences; callers that overlay subzones
+// independently should diff those directly.
+func (z ZoneConfig) DiffFrom(other ZoneConfig) ZoneConfigDiff {
+	var diff ZoneConfigDiff
+	if z.RangeMinBytes != other.RangeMinBytes {
+		diff.ChangedFields = append(diff.ChangedFields, "range_min_bytes")
+	}
+	if z.RangeMaxBytes != other.RangeMaxBytes {
+		diff.ChangedFields = append(diff.ChangedFields, "range_max_bytes")
+	}
+	if z.GC != other.GC {
+		diff.ChangedFields = append(diff.ChangedFields, "gc")
+	}
+	if z.NumReplicas != other.NumReplicas {
+		diff.ChangedFields = append(diff.ChangedFields, "num_replicas")
+	}
+	diff.AddedConstraints, diff.RemovedConstraints = diffConstraintsConjunctions(z.Constraints, other.Constraints)
+	return diff
+}
+
+// ZoneSpecifier identifies the target of a zone config: one of the built-in
+// named zones, a database, a table, or a specific index or partition of a
+// table.
+type ZoneSpecifier struct {
+	// NamedZone, if set, names one of the built-in zones (e.g. "default").
+	// Database and Table are unused in this case.
+	NamedZone string
+	// Database names the target database. It is set whenever NamedZone is
+	// not.
+	Database string
+	// Table names a table within Database. It is empty when the specifier
+	// targets the database as a whole.
+	Table string
+	// IndexName, if set, restricts the specifier to a single index of
+	// Table. It is only valid alongside Table.
+	IndexName string
+	// Partition, if set, restricts the specifier to a single partition of
+	// Table (or of IndexName, if also set). It is only valid alongside
+	// Table.
+	Partition string
+}
+
+var sqlKeywords = map[string]bool{
+	"table": true,
+	"user":  true,
+	"order": true,
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z'):
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func formatNamePart(s string) string {
+	if isIdentifier(s) && !sqlKeywords[strings.ToLower(s)] {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// nameScanner tokenizes a dotted, `@`-delimited, possibly quoted identifier
+// path such as `db.tbl@idx.partition`.
+type nameScanner struct {
+	s string
+	i int
+}
+
+func (p *nameScanner) done() bool { return p.i >= len(p.s) }
+
+func (p *nameScanner) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+// readIdent consumes a single identifier: a quoted name (with "" as an
+// escaped embedded quote), or a bare run of identifier characters that must
+// not collide with a SQL keyword.
+func (p *nameScanner) readIdent() (string, error) {
+	if p.done() {
+		return "", fmt.Errorf("missing zone name")
+	}
+	if p.s[p.i] == '"' {
+		j := p.i + 1
+		var buf strings.Builder
+		closed := false
+		for j < len(p.s) {
+			if p.s[j] == '"' {
+				if j+1 < len(p.s) && p.s[j+1] == '"' {
+					buf.WriteByte('"')
+					j += 2
+					continue
+				}
+				closed = true
+				j++
+				break
+			}
+			buf.WriteByte(p.s[j])
+			j++
+		}
+		if !closed {
+			return "", fmt.Errorf("malformed name: %q: unterminated quoted name", p.s)
+		}
+		p.i = j
+		return buf.String(), nil
+	}
+	j := p.i
+	for j < len(p.s) && p.s[j] != '.' && p.s[j] != '@' {
+		j++
+	}
+	part := p.s[p.i:j]
+	if !isIdentifier(part) {
+		return "", fmt.Errorf("malformed name: %q", p.s)
+	}
+	if sqlKeywords[strings.ToLower(part)] {
+		return "", fmt.Errorf("malformed name: %q", part)
+	}
+	p.i = j
+	return part, nil
+}
+
+// ParseCLIZoneSpecifier parses a CLI zone specifier, as accepted by commands
+// like `cockroach zone set`. The grammar is:
+//
+//	.<named-zone>
+//	<database>
+//	<database>.<table>
+//	<database>.<table>@<index>
+//	<database>.<table>.<partition>
+//	<database>.<table>@<index>.<partition>
+//
+// where <database>, <table>, <index>, and <partition> may be quoted with
+// double quotes (doubling an embedded quote to escape it) when they collide
+// with a SQL keyword or contain characters that aren't valid in a bare
+// identifier.
+func ParseCLIZoneSpecifier(s string) (ZoneSpecifier, error) {
+	if len(s) > 0 && s[0] == '.' {
+		name := s[1:]
+		if name == "" {
+			return ZoneSpecifier{}, fmt.Errorf("missing zone name")
+		}
+		return ZoneSpecifier{NamedZone: name}, nil
+	}
+
+	p := &nameScanner{s: s}
+	database, err := p.readIdent()
+	if err != nil {
+		return ZoneSpecifier{}, err
+	}
+	zs := ZoneSpecifier{Database: database}
+	if p.done() {
+		return zs, nil
+	}
+
+	if p.peek() != '.' {
+		return ZoneSpecifier{}, fmt.Errorf("malformed name: %q", s)
+	}
+	p.i++
+	table, err := p.readIdent()
+	if err != nil {
+		return ZoneSpecifier{}, err
+	}
+	zs.Table = table
+
+	if p.peek() == '@' {
+		p.i++
+		index, err := p.readIdent()
+		if err != nil {
+			return ZoneSpecifier{}, err
+		}
+		zs.IndexName = index
+	}
+
+	if !p.done() {
+		if p.peek() != '.' {
+			return ZoneSpecifier{}, fmt.Errorf("malformed name: %q", s)
+		}
+		p.i++
+		partition, err := p.readIdent()
+		if err != nil {
+			return ZoneSpecifier{}, err
+		}
+		zs.Partition = partition
+		if !p.done() {
+			return ZoneSpecifier{}, fmt.Errorf("malformed name: %q", s)
+		}
+	}
+
+	return zs, nil
+}
+
+// CLIZoneSpecifier is the inverse of ParseCLIZoneSpecifier: it renders a
+// ZoneSpecifier back into CLI syntax, quoting name parts as necessary.
+func CLIZoneSpecifier(zs ZoneSpecifier) string {
+	if zs.NamedZone != "" {
+		return "." + zs.NamedZone
+	}
+	var b strings.Builder
+	b.WriteString(formatNamePart(zs.Database))
+	if zs.Table != "" {
+		b.WriteByte('.')
+		b.WriteString(formatNamePart(zs.Table))
+		if zs.IndexName != "" {
+			b.WriteByte('@')
+			b.WriteString(formatNamePart(zs.IndexName))
+		}
+		if zs.Partition != "" {
+			b.WriteByte('.')
+			b.WriteString(formatNamePart(zs.Partition))
+		}
+	}
+	return b.String()
+}
+
+// SubzoneKey identifies a subzone within the table targeted by a
+// ZoneSpecifier: either an index (PartitionName empty) or a partition of an
+// index. The zero value refers to the table (or database, or named zone) as
+// a whole, with no subzone override.
+type SubzoneKey struct {
+	IndexID       uint32
+	PartitionName string
+}
+
+// ZoneSpecifierResolver supplies the name resolution ResolveZoneSpecifier
+// needs to turn a parsed ZoneSpecifier into the ID of the object it targets
+// and, for index or partition targets, the SubzoneKey within that object.
+type ZoneSpecifierResolver struct {
+	// ResolveName looks up the ID of the object named name within parentID
+	// (0 for top-level, i.e. database, lookups).
+	ResolveName func(parentID uint32, name string) (uint32, error)
+	// ResolveIndex looks up the ID of the index named indexName on the
+	// table with the given ID.
+	ResolveIndex func(tableID uint32, indexName string) (indexID uint32, err error)
+	// ResolvePartition verifies that a partition named partitionName exists
+	// on the given index (indexID is 0 for the table's primary index) of
+	// the table with the given ID.
+	ResolvePartition func(tableID, indexID uint32, partitionName string) error
+}
+
+// ResolveZoneSpecifier resolves a ZoneSpecifier to the ID of the zone config
+// key it identifies (a named zone ID, a database ID, or a table ID) along
+// with the SubzoneKey of the index or partition it targets, if any.
+func ResolveZoneSpecifier(zs ZoneSpecifier, r ZoneSpecifierResolver) (uint32, SubzoneKey, error) {
+	if zs.NamedZone != "" {
+		if id, ok := NamedZones[zs.NamedZone]; ok {
+			return id, SubzoneKey{}, nil
+		}
+		return 0, SubzoneKey{}, fmt.Errorf("%q is not a built-in zone", zs.NamedZone)
+	}
+
+	dbID, err := r.ResolveName(0, zs.Database)
+	if err != nil {
+		return 0, SubzoneKey{}, err
+	}
+	if zs.Table == "" {
+		return dbID, SubzoneKey{}, nil
+	}
+	tableID, err := r.ResolveName(dbID, zs.Table)
+	if err != nil {
+		return 0, SubzoneKey{}, err
+	}
+
+	var indexID uint32
+	if zs.IndexName != "" {
+		indexID, err = r.ResolveIndex(tableID, zs.IndexName)
+		if err != nil {
+			return 0, SubzoneKey{}, err
+		}
+	}
+
+	if zs.Partition == "" {
+		if zs.IndexName == "" {
+			return tableID, SubzoneKey{}, nil
+		}
+		return tableID, SubzoneKey{IndexID: indexID}, nil
+	}
+	if err := r.ResolvePartition(tableID, indexID, zs.Partition); err != nil {
+		return 0, SubzoneKey{}, err
+	}
+	return tableID, SubzoneKey{IndexID: indexID, PartitionName: zs.Partition}, nil
+}
+
+// ZoneSpecifierFromIDResolver supplies the name resolution
+// ZoneSpecifierFromID needs to walk from an ID and SubzoneKey back to the
+// ZoneSpecifier (and ultimately the CLI form) that resolves to them.
+type ZoneSpecifierFromIDResolver struct {
+	// ResolveID looks up the parent ID and name of the object with the
+	// given ID.
+	ResolveID func(id uint32) (parentID uint32, name string, err error)
+	// ResolveIndexName looks up the name of the index with the given ID on
+	// the table with the given ID.
+	ResolveIndexName func(tableID, indexID uint32) (string, error)
+}
+
+// ZoneSpecifierFromID is the inverse of ResolveZoneSpecifier: given an ID and
+// a SubzoneKey, it reconstructs the ZoneSpecifier that would resolve back to
+// them.
+func ZoneSpecifierFromID(
+	id uint32, subzone SubzoneKey, r ZoneSpecifierFromIDResolver,
+) (ZoneSpecifier, error) {
+	if name, ok := NamedZonesByID[id]; ok {
+		return ZoneSpecifier{NamedZone: name}, nil
+	}
+
+	parentID, name, err := r.ResolveID(id)
+	if err != nil {
+		return ZoneSpecifier{}, err
+	}
+
+	var zs ZoneSpecifier
+	if parentID == 0 {
+		zs = ZoneSpecifier{Database: name}
+	} else {
+		_, parentName, err := r.ResolveID(parentID)
+		if err != nil {
+			return ZoneSpecifier{}, err
+		}
+		zs = ZoneSpecifier{Database: parentName, Table: name}
+	}
+
+	if subzone.IndexID != 0 {
+		indexName, err := r.ResolveIndexName(id, subzone.IndexID)
+		if err != nil {
+			return ZoneSpecifier{}, err
+		}
+		zs.IndexName = indexName
+	}
+	zs.Partition = subzone.PartitionName
+
+	return zs, nil
+}
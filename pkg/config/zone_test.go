@@ -15,6 +15,7 @@
 package config_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -63,6 +64,28 @@ func TestZoneConfigValidate(t *testing.T) {
 			},
 			"is greater than or equal to RangeMaxBytes",
 		},
+		{
+			config.ZoneConfig{
+				NumReplicas:   3,
+				RangeMinBytes: 1,
+				RangeMaxBytes: config.DefaultZoneConfig().RangeMaxBytes,
+				Constraints: []config.ConstraintsConjunction{
+					{
+						NumReplicas: 2,
+						Constraints: []config.Constraint{
+							{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-east1"},
+						},
+					},
+					{
+						NumReplicas: 2,
+						Constraints: []config.Constraint{
+							{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-west1"},
+						},
+					},
+				},
+			},
+			"the number of replicas specified in constraints (4) is greater than the configured number of replicas (3)",
+		},
 	}
 	for i, c := range testCases {
 		err := c.cfg.Validate()
@@ -84,21 +107,204 @@ func TestZoneConfigMarshalYAML(t *testing.T) {
 			TTLSeconds: 1,
 		},
 		NumReplicas: 1,
-		Constraints: config.Constraints{
-			Constraints: []config.Constraint{
-				{
-					Type:  config.Constraint_POSITIVE,
-					Value: "foo",
+		Constraints: []config.ConstraintsConjunction{
+			{
+				Constraints: []config.Constraint{
+					{
+						Type:  config.Constraint_POSITIVE,
+						Value: "foo",
+					},
+					{
+						Type:  config.Constraint_REQUIRED,
+						Key:   "duck",
+						Value: "foo",
+					},
+					{
+						Type:  config.Constraint_PROHIBITED,
+						Key:   "duck",
+						Value: "foo",
+					},
+				},
+			},
+		},
+	}
+
+	expected := `range_min_bytes: 1
+range_max_bytes: 1
+gc:
+  ttlseconds: 1
+num_replicas: 1
+constraints: [foo, +duck=foo, -duck=foo]
+`
+
+	body, err := yaml.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != expected {
+		t.Fatalf("yaml.Marshal(%+v) = %s; not %s", original, body, expected)
+	}
+
+	var unmarshaled config.ZoneConfig
+	if err := yaml.UnmarshalStrict(body, &unmarshaled); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&unmarshaled, &original) {
+		t.Errorf("yaml.UnmarshalStrict(%q) = %+v; not %+v", body, unmarshaled, original)
+	}
+}
+
+// TestZoneConfigMarshalJSON makes sure that ZoneConfig is correctly marshaled
+// to JSON and back, using the same compact constraint syntax as
+// TestZoneConfigMarshalYAML rather than verbose protobuf-JSON.
+func TestZoneConfigMarshalJSON(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	original := config.ZoneConfig{
+		RangeMinBytes: 1,
+		RangeMaxBytes: 1,
+		GC: config.GCPolicy{
+			TTLSeconds: 1,
+		},
+		NumReplicas: 1,
+		Constraints: []config.ConstraintsConjunction{
+			{
+				Constraints: []config.Constraint{
+					{
+						Type:  config.Constraint_POSITIVE,
+						Value: "foo",
+					},
+					{
+						Type:  config.Constraint_REQUIRED,
+						Key:   "duck",
+						Value: "foo",
+					},
+					{
+						Type:  config.Constraint_PROHIBITED,
+						Key:   "duck",
+						Value: "foo",
+					},
 				},
-				{
-					Type:  config.Constraint_REQUIRED,
-					Key:   "duck",
-					Value: "foo",
+			},
+		},
+	}
+
+	expected := `{"range_min_bytes":1,"range_max_bytes":1,"gc":{"ttlseconds":1},"num_replicas":1,"constraints":["foo","+duck=foo","-duck=foo"]}`
+
+	body, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != expected {
+		t.Fatalf("json.Marshal(%+v) = %s; not %s", original, body, expected)
+	}
+
+	var unmarshaled config.ZoneConfig
+	if err := json.Unmarshal(body, &unmarshaled); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&unmarshaled, &original) {
+		t.Errorf("json.Unmarshal(%q) = %+v; not %+v", body, unmarshaled, original)
+	}
+}
+
+// TestZoneConfigMarshalYAMLConstraintsConjunction makes sure that a
+// ZoneConfig with more than one constraints conjunction (each binding a
+// subset of replicas) and lease preferences marshals to and from the
+// map-of-count-to-list and ordered-list YAML forms.
+func TestZoneConfigMarshalYAMLConstraintsConjunction(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	original := config.ZoneConfig{
+		RangeMinBytes: 1,
+		RangeMaxBytes: 1,
+		GC: config.GCPolicy{
+			TTLSeconds: 1,
+		},
+		NumReplicas: 5,
+		Constraints: []config.ConstraintsConjunction{
+			{
+				NumReplicas: 2,
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-east1"},
+				},
+			},
+			{
+				NumReplicas: 2,
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-west1"},
+				},
+			},
+		},
+		LeasePreferences: []config.ConstraintsConjunction{
+			{
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "rack", Value: "ssd"},
+				},
+			},
+		},
+	}
+
+	expected := `range_min_bytes: 1
+range_max_bytes: 1
+gc:
+  ttlseconds: 1
+num_replicas: 5
+constraints: {2: [+region=us-east1], 2: [+region=us-west1]}
+lease_preferences: [[+rack=ssd]]
+`
+
+	body, err := yaml.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != expected {
+		t.Fatalf("yaml.Marshal(%+v) = %s; not %s", original, body, expected)
+	}
+
+	var unmarshaled config.ZoneConfig
+	if err := yaml.UnmarshalStrict(body, &unmarshaled); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&unmarshaled, &original) {
+		t.Errorf("yaml.UnmarshalStrict(%q) = %+v; not %+v", body, unmarshaled, original)
+	}
+}
+
+// TestZoneConfigMarshalYAMLSubzones makes sure that a ZoneConfig's Subzones
+// -- the per-index and per-partition overrides attached to a table's zone
+// config -- survive a YAML round trip.
+func TestZoneConfigMarshalYAMLSubzones(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	original := config.ZoneConfig{
+		RangeMinBytes: 1,
+		RangeMaxBytes: 1,
+		GC: config.GCPolicy{
+			TTLSeconds: 1,
+		},
+		NumReplicas: 1,
+		Subzones: []config.Subzone{
+			{
+				IndexID: 2,
+				Config: config.ZoneConfig{
+					RangeMinBytes: 1,
+					RangeMaxBytes: 1,
+					GC:            config.GCPolicy{TTLSeconds: 1},
+					NumReplicas:   1,
 				},
-				{
-					Type:  config.Constraint_PROHIBITED,
-					Key:   "duck",
-					Value: "foo",
+			},
+			{
+				IndexID:       2,
+				PartitionName: "west",
+				Config: config.ZoneConfig{
+					RangeMinBytes: 1,
+					RangeMaxBytes: 1,
+					GC:            config.GCPolicy{TTLSeconds: 1},
+					NumReplicas:   1,
+					Constraints: []config.ConstraintsConjunction{
+						{Constraints: []config.Constraint{{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-west1"}}},
+					},
 				},
 			},
 		},
@@ -109,7 +315,23 @@ range_max_bytes: 1
 gc:
   ttlseconds: 1
 num_replicas: 1
-constraints: [foo, +duck=foo, -duck=foo]
+subzones:
+- index_id: 2
+  config:
+    range_min_bytes: 1
+    range_max_bytes: 1
+    gc:
+      ttlseconds: 1
+    num_replicas: 1
+- index_id: 2
+  partition: west
+  config:
+    range_min_bytes: 1
+    range_max_bytes: 1
+    gc:
+      ttlseconds: 1
+    num_replicas: 1
+    constraints: [+region=us-west1]
 `
 
 	body, err := yaml.Marshal(original)
@@ -180,31 +402,81 @@ func TestZoneSpecifiers(t *testing.T) {
 		return 0, "", fmt.Errorf("%d not found", id)
 	}
 
+	// Simulate table "tbl" (id 51) having a secondary index "idx" (id 2) and
+	// partitions "west" on both its primary and secondary index, plus a
+	// SQL-keyword-named partition "order" on its primary index.
+	indexes := map[string]uint32{
+		"idx": 2,
+	}
+	resolveIndex := func(tableID uint32, indexName string) (uint32, error) {
+		if tableID == 51 {
+			if id, ok := indexes[indexName]; ok {
+				return id, nil
+			}
+		}
+		return 0, fmt.Errorf("%q not found", indexName)
+	}
+	partitions := map[string]bool{
+		"51/0/west":  true,
+		"51/2/west":  true,
+		"51/0/order": true,
+	}
+	resolvePartition := func(tableID, indexID uint32, partitionName string) error {
+		if partitions[fmt.Sprintf("%d/%d/%s", tableID, indexID, partitionName)] {
+			return nil
+		}
+		return fmt.Errorf("%q not found", partitionName)
+	}
+	resolveIndexName := func(tableID, indexID uint32) (string, error) {
+		if tableID == 51 {
+			for name, id := range indexes {
+				if id == indexID {
+					return name, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("index %d not found", indexID)
+	}
+	resolver := config.ZoneSpecifierResolver{
+		ResolveName:      resolveName,
+		ResolveIndex:     resolveIndex,
+		ResolvePartition: resolvePartition,
+	}
+
 	for _, tc := range []struct {
 		cliSpecifier string
 		id           int
+		indexID      uint32
+		partition    string
 		err          string
 	}{
-		{".default", 0, ""},
-		{".carl", 42, ""},
-		{".foo", -1, `"foo" is not a built-in zone`},
-		{"db", 50, ""},
-		{".db", -1, `"db" is not a built-in zone`},
-		{"db.tbl", 51, ""},
-		{"tbl", -1, `"tbl" not found`},
-		{"table", -1, `malformed name: "table"`}, // SQL keyword; requires quotes
-		{`"table"`, -1, `"table" not found`},
-		{"user", -1, "malformed name: \"user\""}, // SQL keyword; requires quotes
-		{`"user"`, 52, ""},
-		{`"."`, 53, ""},
-		{`.`, -1, `missing zone name`},
-		{`".table."`, -1, `".table." not found`},
-		{`".".".table."`, 54, ""},
-		{`.table.`, -1, `"table." is not a built-in zone`},
-		{"carl", 55, ""},
-		{"carl.toys", 56, ""},
-		{"carl.love", -1, `"love" not found`},
-		{"; DROP DATABASE system", -1, `malformed name`},
+		{".default", 0, 0, "", ""},
+		{".carl", 42, 0, "", ""},
+		{".foo", -1, 0, "", `"foo" is not a built-in zone`},
+		{"db", 50, 0, "", ""},
+		{".db", -1, 0, "", `"db" is not a built-in zone`},
+		{"db.tbl", 51, 0, "", ""},
+		{"tbl", -1, 0, "", `"tbl" not found`},
+		{"table", -1, 0, "", `malformed name: "table"`}, // SQL keyword; requires quotes
+		{`"table"`, -1, 0, "", `"table" not found`},
+		{"user", -1, 0, "", "malformed name: \"user\""}, // SQL keyword; requires quotes
+		{`"user"`, 52, 0, "", ""},
+		{`"."`, 53, 0, "", ""},
+		{`.`, -1, 0, "", `missing zone name`},
+		{`".table."`, -1, 0, "", `".table." not found`},
+		{`".".".table."`, 54, 0, "", ""},
+		{`.table.`, -1, 0, "", `"table." is not a built-in zone`},
+		{"carl", 55, 0, "", ""},
+		{"carl.toys", 56, 0, "", ""},
+		{"carl.love", -1, 0, "", `"love" not found`},
+		{"; DROP DATABASE system", -1, 0, "", `malformed name`},
+		{"db.tbl@idx", 51, 2, "", ""},
+		{"db.tbl@nope", -1, 0, "", `"nope" not found`},
+		{"db.tbl.west", 51, 0, "west", ""},
+		{"db.tbl@idx.west", 51, 2, "west", ""},
+		{"db.tbl.nope", -1, 0, "", `"nope" not found`},
+		{`db.tbl."order"`, 51, 0, "order", ""}, // SQL keyword; requires quotes
+		{"db.tbl.order", -1, 0, "", `malformed name: "order"`},
 	} {
 		t.Run(fmt.Sprintf("parse-cli=%s", tc.cliSpecifier), func(t *testing.T) {
 			err := func() error {
@@ -212,13 +484,19 @@ func TestZoneSpecifiers(t *testing.T) {
 				if err != nil {
 					return err
 				}
-				id, err := config.ResolveZoneSpecifier(zs, resolveName)
+				id, subzone, err := config.ResolveZoneSpecifier(zs, resolver)
 				if err != nil {
 					return err
 				}
 				if e, a := tc.id, int(id); a != e {
 					t.Errorf("path %d did not match expected path %d", a, e)
 				}
+				if e, a := tc.indexID, subzone.IndexID; a != e {
+					t.Errorf("index ID %d did not match expected index ID %d", a, e)
+				}
+				if e, a := tc.partition, subzone.PartitionName; a != e {
+					t.Errorf("partition %q did not match expected partition %q", a, e)
+				}
 				if e, a := tc.cliSpecifier, config.CLIZoneSpecifier(zs); e != a {
 					t.Errorf("expected %q to roundtrip, but got %q", e, a)
 				}
@@ -230,26 +508,36 @@ func TestZoneSpecifiers(t *testing.T) {
 		})
 	}
 
+	fromIDResolver := config.ZoneSpecifierFromIDResolver{
+		ResolveID:        resolveID,
+		ResolveIndexName: resolveIndexName,
+	}
+
 	for _, tc := range []struct {
 		id           uint32
+		subzone      config.SubzoneKey
 		cliSpecifier string
 		err          string
 	}{
-		{0, ".default", ""},
-		{41, "", "41 not found"},
-		{42, ".carl", ""},
-		{50, "db", ""},
-		{51, "db.tbl", ""},
-		{52, `"user"`, ""},
-		{53, `"."`, ""},
-		{54, `".".".table."`, ""},
-		{55, "carl", ""},
-		{56, "carl.toys", ""},
-		{57, "", "9000 not found"},
-		{58, "", "58 not found"},
+		{0, config.SubzoneKey{}, ".default", ""},
+		{41, config.SubzoneKey{}, "", "41 not found"},
+		{42, config.SubzoneKey{}, ".carl", ""},
+		{50, config.SubzoneKey{}, "db", ""},
+		{51, config.SubzoneKey{}, "db.tbl", ""},
+		{52, config.SubzoneKey{}, `"user"`, ""},
+		{53, config.SubzoneKey{}, `"."`, ""},
+		{54, config.SubzoneKey{}, `".".".table."`, ""},
+		{55, config.SubzoneKey{}, "carl", ""},
+		{56, config.SubzoneKey{}, "carl.toys", ""},
+		{57, config.SubzoneKey{}, "", "9000 not found"},
+		{58, config.SubzoneKey{}, "", "58 not found"},
+		{51, config.SubzoneKey{IndexID: 2}, "db.tbl@idx", ""},
+		{51, config.SubzoneKey{PartitionName: "west"}, "db.tbl.west", ""},
+		{51, config.SubzoneKey{IndexID: 2, PartitionName: "west"}, "db.tbl@idx.west", ""},
+		{51, config.SubzoneKey{IndexID: 3}, "", "index 3 not found"},
 	} {
 		t.Run(fmt.Sprintf("resolve-id=%d", tc.id), func(t *testing.T) {
-			zs, err := config.ZoneSpecifierFromID(tc.id, resolveID)
+			zs, err := config.ZoneSpecifierFromID(tc.id, tc.subzone, fromIDResolver)
 			if !testutils.IsError(err, tc.err) {
 				t.Errorf("unable to lookup ID %d: %s", tc.id, err)
 			}
@@ -262,3 +550,72 @@ func TestZoneSpecifiers(t *testing.T) {
 		})
 	}
 }
+
+// TestZoneConfigDiffFrom verifies that DiffFrom reports changed scalar
+// fields and added/removed constraints conjunctions, and that it reports no
+// differences for an identical config.
+func TestZoneConfigDiffFrom(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	base := config.ZoneConfig{
+		RangeMinBytes: 1,
+		RangeMaxBytes: 2,
+		GC:            config.GCPolicy{TTLSeconds: 1},
+		NumReplicas:   5,
+		Constraints: []config.ConstraintsConjunction{
+			{
+				NumReplicas: 2,
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-east1"},
+				},
+			},
+			{
+				NumReplicas: 2,
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-west1"},
+				},
+			},
+		},
+	}
+
+	if diff := base.DiffFrom(base); !diff.Empty() {
+		t.Errorf("expected no diff between identical configs, got %+v", diff)
+	}
+
+	other := config.ZoneConfig{
+		RangeMinBytes: 1,
+		RangeMaxBytes: 3,
+		GC:            config.GCPolicy{TTLSeconds: 2},
+		NumReplicas:   5,
+		Constraints: []config.ConstraintsConjunction{
+			{
+				NumReplicas: 2,
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "region", Value: "us-east1"},
+				},
+			},
+			{
+				NumReplicas: 2,
+				Constraints: []config.Constraint{
+					{Type: config.Constraint_REQUIRED, Key: "region", Value: "eu-west1"},
+				},
+			},
+		},
+	}
+
+	diff := base.DiffFrom(other)
+
+	if e, a := []string{"range_max_bytes", "gc"}, diff.ChangedFields; fmt.Sprint(e) != fmt.Sprint(a) {
+		t.Errorf("expected changed fields %v, got %v", e, a)
+	}
+	if e, a := 1, len(diff.AddedConstraints); e != a {
+		t.Errorf("expected %d added constraints conjunctions, got %d: %+v", e, a, diff.AddedConstraints)
+	} else if diff.AddedConstraints[0].Constraints[0].Value != "us-west1" {
+		t.Errorf("expected added conjunction for us-west1, got %+v", diff.AddedConstraints[0])
+	}
+	if e, a := 1, len(diff.RemovedConstraints); e != a {
+		t.Errorf("expected %d removed constraints conjunctions, got %d: %+v", e, a, diff.RemovedConstraints)
+	} else if diff.RemovedConstraints[0].Constraints[0].Value != "eu-west1" {
+		t.Errorf("expected removed conjunction for eu-west1, got %+v", diff.RemovedConstraints[0])
+	}
+}